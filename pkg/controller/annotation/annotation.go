@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package annotation parses the cloud.google.com/managed-certificates
+// Ingress annotation into a typed, validated list of ManagedCertificate
+// references.
+package annotation
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	entrySplitBy  = ","
+	optionSplitBy = "&"
+	optionAssign  = "="
+)
+
+// recognized per-entry option keys.
+const (
+	optionProvider = "provider"
+)
+
+// Ref is a single ManagedCertificate reference parsed out of the
+// annotation, with whichever per-entry options were set alongside it.
+type Ref struct {
+	Namespace string
+	Name      string
+	Provider  string
+}
+
+// Parse splits annotationValue into its comma-separated entries and
+// validates each one. An entry is either a bare name - resolved against
+// defaultNamespace, the namespace of the Ingress carrying the annotation -
+// or a namespace/name pair for a cross-namespace reference, optionally
+// followed by "?key=value&key=value" options. Parse rejects empty entries,
+// malformed namespace/name or option syntax, unrecognized option keys, and
+// duplicate (namespace, name) pairs so callers never have to re-validate.
+func Parse(annotationValue, defaultNamespace string) ([]Ref, error) {
+	if annotationValue == "" {
+		return nil, nil
+	}
+
+	var refs []Ref
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(annotationValue, entrySplitBy) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return nil, fmt.Errorf("annotation %q has an empty entry", annotationValue)
+		}
+
+		ref, err := parseEntry(entry, defaultNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("annotation entry %q: %v", entry, err)
+		}
+
+		key := ref.Namespace + "/" + ref.Name
+		if seen[key] {
+			return nil, fmt.Errorf("annotation %q references %s more than once", annotationValue, key)
+		}
+		seen[key] = true
+
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
+
+func parseEntry(entry, defaultNamespace string) (Ref, error) {
+	nameAndOptions := strings.SplitN(entry, "?", 2)
+
+	ref := Ref{Namespace: defaultNamespace}
+	if idx := strings.Index(nameAndOptions[0], "/"); idx >= 0 {
+		ref.Namespace = nameAndOptions[0][:idx]
+		ref.Name = nameAndOptions[0][idx+1:]
+	} else {
+		ref.Name = nameAndOptions[0]
+	}
+
+	if ref.Namespace == "" || ref.Name == "" || strings.Contains(ref.Name, "/") {
+		return Ref{}, fmt.Errorf("expected [namespace/]name, both must be non-empty")
+	}
+
+	if len(nameAndOptions) == 2 {
+		if err := parseOptions(nameAndOptions[1], &ref); err != nil {
+			return Ref{}, err
+		}
+	}
+
+	return ref, nil
+}
+
+func parseOptions(options string, ref *Ref) error {
+	for _, option := range strings.Split(options, optionSplitBy) {
+		kv := strings.SplitN(option, optionAssign, 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return fmt.Errorf("malformed option %q, expected key=value", option)
+		}
+
+		switch kv[0] {
+		case optionProvider:
+			ref.Provider = kv[1]
+		default:
+			return fmt.Errorf("unrecognized option %q", kv[0])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package annotation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		annotationValue  string
+		defaultNamespace string
+		success          bool
+		refsOut          []Ref
+	}{
+		{"Empty annotation", "", "default", true, nil},
+		{"Single bare name", "foo", "default", true, []Ref{{Namespace: "default", Name: "foo"}}},
+		{"Multiple bare names", "foo,bar", "default", true, []Ref{
+			{Namespace: "default", Name: "foo"},
+			{Namespace: "default", Name: "bar"},
+		}},
+		{"Cross-namespace reference", "other/foo", "default", true, []Ref{{Namespace: "other", Name: "foo"}}},
+		{"Cross-namespace reference with provider option", "other/foo?provider=acme", "default", true, []Ref{
+			{Namespace: "other", Name: "foo", Provider: "acme"},
+		}},
+		{"Empty entry is rejected", "foo,,bar", "default", false, nil},
+		{"Missing name after slash is rejected", "other/", "default", false, nil},
+		{"Missing namespace before slash is rejected", "/foo", "default", false, nil},
+		{"Malformed option is rejected", "foo?provider", "default", false, nil},
+		{"Unrecognized option is rejected", "foo?bogus=1", "default", false, nil},
+		{"Retired sslPolicy option is rejected", "foo?sslPolicy=restricted", "default", false, nil},
+		{"Duplicate reference is rejected", "foo,foo", "default", false, nil},
+		{"Duplicate cross-namespace reference is rejected", "other/foo,other/foo", "default", false, nil},
+		{"Same name in different namespaces is allowed", "foo,other/foo", "default", true, []Ref{
+			{Namespace: "default", Name: "foo"},
+			{Namespace: "other", Name: "foo"},
+		}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			refs, err := Parse(testCase.annotationValue, testCase.defaultNamespace)
+
+			if (err == nil) != testCase.success {
+				t.Fatalf("Parse(%q) err = %v, want success: %t", testCase.annotationValue, err, testCase.success)
+			}
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(refs, testCase.refsOut) {
+				t.Errorf("Parse(%q) = %#v, want %#v", testCase.annotationValue, refs, testCase.refsOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RBACAuthorizer is the CrossNamespaceAuthorizer backed by a
+// SubjectAccessReview, the same mechanism voyager uses to gate its own
+// cross-namespace Ingress annotations: a cross-namespace reference is
+// allowed only if RBAC grants "get" on the target ManagedCertificate to
+// every service account in the source namespace.
+type RBACAuthorizer struct {
+	client kubernetes.Interface
+}
+
+// NewRBACAuthorizer returns a RBACAuthorizer that reviews access through
+// client.
+func NewRBACAuthorizer(client kubernetes.Interface) *RBACAuthorizer {
+	return &RBACAuthorizer{client: client}
+}
+
+// Allowed reports whether RBAC grants "get" on the targetNamespace/name
+// ManagedCertificate to the system:serviceaccounts:sourceNamespace group,
+// i.e. whether the namespace the referencing Ingress lives in has been
+// granted access to the ManagedCertificate it wants to reference.
+func (a *RBACAuthorizer) Allowed(sourceNamespace, targetNamespace, name string) (bool, error) {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			Groups: []string{"system:serviceaccounts:" + sourceNamespace},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: targetNamespace,
+				Verb:      "get",
+				Group:     "gke.googleapis.com",
+				Resource:  "managedcertificates",
+				Name:      name,
+			},
+		},
+	}
+
+	result, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(review)
+	if err != nil {
+		return false, err
+	}
+	return result.Status.Allowed, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	api "managed-certs-gke/pkg/apis/gke.googleapis.com/v1alpha1"
+	clientset "managed-certs-gke/pkg/client/clientset/versioned"
+	listers "managed-certs-gke/pkg/client/listers/gke.googleapis.com/v1alpha1"
+	"managed-certs-gke/pkg/ingress"
+	"managed-certs-gke/pkg/provider"
+	"managed-certs-gke/pkg/state"
+)
+
+// IngressController watches Ingress resources via shared informers and
+// enqueues referenced ManagedCertificates for processing by McertController.
+// Both informerExtensions (extensions/v1beta1) and informerNetworking
+// (networking.k8s.io/v1) are watched, since extensions/v1beta1 Ingress is
+// removed entirely on modern clusters while older clusters may not yet
+// serve networking.k8s.io/v1; informerNetworking is nil on a cluster that
+// doesn't serve that group.
+type IngressController struct {
+	client             ingress.Client
+	informerExtensions cache.SharedIndexInformer
+	informerNetworking cache.SharedIndexInformer
+	queue              workqueue.RateLimitingInterface
+	recorder           record.EventRecorder
+}
+
+// McertController reconciles ManagedCertificate resources against whichever
+// provider.CertificateProvider is selected for each ManagedCertificate.
+type McertController struct {
+	client    clientset.Interface
+	lister    listers.ManagedCertificateLister
+	providers map[provider.Name]provider.CertificateProvider
+	state     state.State
+	queue     workqueue.RateLimitingInterface
+	recorder  record.EventRecorder
+}
+
+// defaultProvider is used for ManagedCertificates that don't set
+// spec.Provider, so existing objects keep resolving to the GCE backend they
+// always used.
+const defaultProvider = provider.GCE
+
+// providerFor resolves the provider.CertificateProvider a ManagedCertificate
+// should be issued through.
+func (c *McertController) providerFor(mcert *api.ManagedCertificate) (provider.CertificateProvider, error) {
+	name := defaultProvider
+	if mcert.Spec.Provider != "" {
+		name = provider.Name(mcert.Spec.Provider)
+	}
+
+	p, exists := c.providers[name]
+	if !exists {
+		return nil, fmt.Errorf("no CertificateProvider registered for %q", name)
+	}
+	return p, nil
+}
+
+// CrossNamespaceAuthorizer decides whether an Ingress in sourceNamespace is
+// allowed to reference a ManagedCertificate called name in targetNamespace,
+// typically backed by a Kubernetes SubjectAccessReview against the service
+// accounts of the Ingress's namespace.
+type CrossNamespaceAuthorizer interface {
+	Allowed(sourceNamespace, targetNamespace, name string) (bool, error)
+}
+
+// Controller glues together IngressController and McertController so
+// handleIngress can enqueue work onto the mcert queue.
+type Controller struct {
+	Ingress    *IngressController
+	Mcert      *McertController
+	authorizer CrossNamespaceAuthorizer
+}
+
+// denyAllAuthorizer is the CrossNamespaceAuthorizer authorizerFor falls back
+// to when Controller.authorizer is unset, so a cross-namespace reference
+// fails closed instead of nil-pointer panicking.
+type denyAllAuthorizer struct{}
+
+func (denyAllAuthorizer) Allowed(sourceNamespace, targetNamespace, name string) (bool, error) {
+	return false, nil
+}
+
+// authorizerFor resolves the CrossNamespaceAuthorizer cross-namespace
+// Ingress references should be checked against, mirroring providerFor's
+// defaultProvider fallback for an unset spec.Provider.
+func (c *Controller) authorizerFor() CrossNamespaceAuthorizer {
+	if c.authorizer != nil {
+		return c.authorizer
+	}
+	return denyAllAuthorizer{}
+}
+
+// SetAuthorizer configures the CrossNamespaceAuthorizer cross-namespace
+// Ingress references are checked against, typically a RBACAuthorizer wired
+// to the in-cluster clientset. Leaving it unset keeps every cross-namespace
+// reference denied by authorizerFor's denyAllAuthorizer fallback.
+func (c *Controller) SetAuthorizer(authorizer CrossNamespaceAuthorizer) {
+	c.authorizer = authorizer
+}
+
+func (c *IngressController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *McertController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	c.queue.Add(key)
+}
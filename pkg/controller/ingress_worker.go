@@ -3,42 +3,111 @@ package controller
 import (
 	"fmt"
 	"github.com/golang/glog"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
-	"strings"
-	"time"
+
+	"managed-certs-gke/pkg/controller/annotation"
+	"managed-certs-gke/pkg/metrics"
 )
 
 const (
-	annotation = "cloud.google.com/managed-certificates"
-	splitBy = ","
+	annotationKey = "cloud.google.com/managed-certificates"
+
+	eventReasonAnnotationParsed   = "Parsed"
+	eventReasonCertificateMissing = "NotFound"
+	eventReasonAnnotationInvalid  = "InvalidAnnotation"
+	eventReasonForbidden          = "Forbidden"
 )
 
 
-func (c *IngressController) runWatcher() {
-	watcher, err := c.client.Watch()
+// runInformer registers event handlers on both shared Ingress informers and
+// blocks until stopCh is closed. Unlike the old Watch()-based loop, which
+// polled its channel with a default: branch and a time.Sleep(time.Second)
+// and silently stopped recovering once the watch broke, the informers'
+// reflectors reconnect on their own and HasSynced lets callers know once
+// the initial list has landed. informerNetworking is nil on a cluster that
+// doesn't serve networking.k8s.io/v1 Ingress, so only informerExtensions is
+// waited on there.
+func (c *Controller) runInformer(stopCh <-chan struct{}) error {
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: c.Ingress.enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			c.Ingress.enqueue(new)
+		},
+		DeleteFunc: c.handleDeleted,
+	}
+
+	hasSynced := []cache.InformerSynced{c.Ingress.informerExtensions.HasSynced}
+	c.Ingress.informerExtensions.AddEventHandler(handler)
+	go c.Ingress.informerExtensions.Run(stopCh)
+
+	if c.Ingress.informerNetworking != nil {
+		c.Ingress.informerNetworking.AddEventHandler(handler)
+		go c.Ingress.informerNetworking.Run(stopCh)
+		hasSynced = append(hasSynced, c.Ingress.informerNetworking.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, hasSynced...) {
+		return fmt.Errorf("failed waiting for Ingress informer cache to sync")
+	}
+
+	<-stopCh
+	return nil
+}
+
+// handleDeleted drops the deleted Ingress's references to whichever
+// ManagedCertificates its annotation used to point at, and deletes the
+// underlying provider-side certificate for any of them that no longer have
+// a referencing Ingress, instead of leaking it. obj is cast through
+// metav1.Object rather than a concrete Ingress type, since it may have come
+// from either the extensions/v1beta1 or the networking.k8s.io/v1 informer.
+func (c *Controller) handleDeleted(obj interface{}) {
+	ing, ok := obj.(metav1.Object)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("Expected an Ingress in DeleteFunc but got %#v", obj))
+			return
+		}
+		ing, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("Expected an Ingress in DeletedFinalStateUnknown but got %#v", tombstone.Obj))
+			return
+		}
+	}
+
+	key := ing.GetNamespace() + "/" + ing.GetName()
+
+	annotationValue, present := ing.GetAnnotations()[annotationKey]
+	if !present {
+		return
+	}
 
+	refs, err := annotation.Parse(annotationValue, ing.GetNamespace())
 	if err != nil {
-		runtime.HandleError(err)
+		// The annotation was already invalid while the Ingress was live, so
+		// there is nothing new to reference-count on its way out.
 		return
 	}
 
-	for {
-		select {
-		case event := <-watcher.ResultChan():
-			if event.Type == watch.Added || event.Type == watch.Modified {
-				c.enqueue(event.Object)
-			}
-		default:
+	for _, ref := range refs {
+		stateKey := ref.Namespace + "/" + ref.Name
+		remaining := c.Mcert.state.RemoveReference(stateKey, key)
+		if remaining > 0 {
+			continue
 		}
 
-		if c.queue.ShuttingDown() {
-			watcher.Stop()
-			return
+		glog.Infof("Ingress %s was the last reference to Managed Certificate %s, deleting its SslCertificate", key, stateKey)
+		mcert, err := c.Mcert.lister.ManagedCertificates(ref.Namespace).Get(ref.Name)
+		if err != nil {
+			// The ManagedCertificate is gone too, nothing left to reconcile.
+			continue
+		}
+		if err := c.Mcert.deleteSslCertificate(mcert); err != nil {
+			runtime.HandleError(fmt.Errorf("failed to delete SslCertificate for Managed Certificate %s: %v", stateKey, err))
 		}
-
-		time.Sleep(time.Second)
 	}
 }
 
@@ -47,14 +116,6 @@ func (c *Controller) runIngressWorker() {
 	}
 }
 
-func parseAnnotation(annotationValue string) []string {
-	if annotationValue == "" {
-		return []string{}
-	}
-
-	return strings.Split(annotationValue, splitBy)
-}
-
 func (c *Controller) handleIngress(key string) error {
 	ns, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -67,32 +128,64 @@ func (c *Controller) handleIngress(key string) error {
 		return err
 	}
 
-	annotationValue, present := ing.ObjectMeta.Annotations[annotation]
+	annotationValue, present := ing.ObjectMeta.Annotations[annotationKey]
 	if !present {
 		// There is no annotation on this ingress
 		return nil
 	}
 
+	refs, err := annotation.Parse(annotationValue, ns)
+	if err != nil {
+		c.Ingress.recorder.Eventf(ing, apiv1.EventTypeWarning, eventReasonAnnotationInvalid,
+			"Failed to parse annotation %s: %v", annotationKey, err)
+		return nil
+	}
+
 	glog.Infof("Found annotation %s", annotationValue)
+	c.Ingress.recorder.Eventf(ing, apiv1.EventTypeNormal, eventReasonAnnotationParsed,
+		"Parsed annotation %s=%s", annotationKey, annotationValue)
+
+	for _, ref := range refs {
+		if ref.Namespace != ns {
+			allowed, err := c.authorizerFor().Allowed(ns, ref.Namespace, ref.Name)
+			if err != nil || !allowed {
+				c.Ingress.recorder.Eventf(ing, apiv1.EventTypeWarning, eventReasonForbidden,
+					"Not allowed to reference Managed Certificate %s in namespace %s", ref.Name, ref.Namespace)
+				continue
+			}
+		}
 
-	for _, name := range parseAnnotation(annotationValue) {
-		// Assume the namespace is the same as ingress's
-		glog.Infof("Looking up managed certificate %s in namespace %s", name, ns)
-		mcert, err := c.Mcert.lister.ManagedCertificates(ns).Get(name)
+		glog.Infof("Looking up managed certificate %s in namespace %s", ref.Name, ref.Namespace)
+		mcert, err := c.Mcert.lister.ManagedCertificates(ref.Namespace).Get(ref.Name)
 
 		if err != nil {
-			// TODO generate k8s event - can't fetch mcert
+			c.Ingress.recorder.Eventf(ing, apiv1.EventTypeWarning, eventReasonCertificateMissing,
+				"Managed Certificate %s referenced by annotation not found in namespace %s", ref.Name, ref.Namespace)
 			runtime.HandleError(err)
-		} else {
-			glog.Infof("Enqueue managed certificate %s for further processing", name)
-			c.Mcert.enqueue(mcert)
+			continue
 		}
+
+		if ref.Provider != "" && mcert.Spec.Provider != ref.Provider {
+			glog.Infof("Annotation %s pins Managed Certificate %s to provider %s", annotationKey, ref.Name, ref.Provider)
+			mcert.Spec.Provider = ref.Provider
+			mcert, err = c.Mcert.client.GkeV1alpha1().ManagedCertificates(ref.Namespace).Update(mcert)
+			if err != nil {
+				runtime.HandleError(fmt.Errorf("failed to pin Managed Certificate %s to provider %s: %v", ref.Name, ref.Provider, err))
+				continue
+			}
+		}
+
+		c.Mcert.state.AddReference(ref.Namespace+"/"+ref.Name, key)
+		glog.Infof("Enqueue managed certificate %s for further processing", ref.Name)
+		c.Mcert.enqueue(mcert)
 	}
 
 	return nil
 }
 
 func (c *Controller) processNextIngress() bool {
+	metrics.QueueDepth.WithLabelValues("ingress").Set(float64(c.Ingress.queue.Len()))
+
 	obj, shutdown := c.Ingress.queue.Get()
 
 	if shutdown {
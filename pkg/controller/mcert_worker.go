@@ -18,125 +18,274 @@ package controller
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/tools/cache"
 
 	api "managed-certs-gke/pkg/apis/gke.googleapis.com/v1alpha1"
-	"managed-certs-gke/pkg/utils"
+	"managed-certs-gke/pkg/metrics"
+	"managed-certs-gke/pkg/naming"
 )
 
 const (
-	sslActive                              = "ACTIVE"
-	sslFailedNotVisible                    = "FAILED_NOT_VISIBLE"
-	sslFailedCaaChecking                   = "FAILED_CAA_CHECKING"
-	sslFailedCaaForbidden                  = "FAILED_CAA_FORBIDDEN"
-	sslFailedRateLimited                   = "FAILED_RATE_LIMITED"
-	sslManagedCertificateStatusUnspecified = "MANAGED_CERTIFICATE_STATUS_UNSPECIFIED"
-	sslProvisioning                        = "PROVISIONING"
-	sslProvisioningFailed                  = "PROVISIONING_FAILED"
-	sslProvisioningFailedPermanently       = "PROVISIONING_FAILED_PERMANENTLY"
-	sslRenewalFailed                       = "RENEWAL_FAILED"
+	eventReasonCreate       = "Create"
+	eventReasonDelete       = "Delete"
+	eventReasonRotate       = "Rotate"
+	eventReasonStatusUpdate = "Update"
+	eventReasonRateLimited  = "RateLimited"
 )
 
-func translateDomainStatus(status string) (string, error) {
-	switch status {
-	case sslProvisioning:
-		return "Provisioning", nil
-	case sslFailedNotVisible:
-		return "FailedNotVisible", nil
-	case sslFailedCaaChecking:
-		return "FailedCaaChecking", nil
-	case sslFailedCaaForbidden:
-		return "FailedCaaForbidden", nil
-	case sslFailedRateLimited:
-		return "FailedRateLimited", nil
-	case sslActive:
-		return "Active", nil
-	default:
-		return "", fmt.Errorf("Unexpected status %s", status)
+// candidateStateKeySuffix namespaces the c.state entry that tracks an
+// in-flight, not-yet-promoted SslCertificate name, keeping it distinct from
+// the live-name entry stored directly under the namespace/name state key.
+const candidateStateKeySuffix = "#candidate"
+
+// managedCertificateFinalizer blocks deletion of a ManagedCertificate until
+// finalizeDeletion has cleaned up its provider-side certificate. Unlike the
+// Ingress reference-count GC in handleDeleted, which only fires while the
+// controller is up to see the last Ingress disappear, a finalizer is part
+// of the ManagedCertificate object itself: it survives a controller
+// restart and the apiserver enforces it, so cleanup can't be missed by a
+// dropped event.
+const managedCertificateFinalizer = "gke.googleapis.com/managed-certificates-cleanup"
+
+// ensureFinalizer adds managedCertificateFinalizer to mcert if it isn't
+// already present.
+func (c *McertController) ensureFinalizer(mcert *api.ManagedCertificate) error {
+	for _, f := range mcert.Finalizers {
+		if f == managedCertificateFinalizer {
+			return nil
+		}
 	}
+
+	mcert.Finalizers = append(mcert.Finalizers, managedCertificateFinalizer)
+	_, err := c.client.GkeV1alpha1().ManagedCertificates(mcert.Namespace).Update(mcert)
+	return err
+}
+
+// finalizeDeletion deletes the provider-side certificate still attached to
+// mcert, which is being deleted, and removes managedCertificateFinalizer so
+// the apiserver can finish deleting it. It is a no-op if the finalizer was
+// already removed.
+func (c *McertController) finalizeDeletion(mcert *api.ManagedCertificate) error {
+	found := false
+	var remaining []string
+	for _, f := range mcert.Finalizers {
+		if f == managedCertificateFinalizer {
+			found = true
+			continue
+		}
+		remaining = append(remaining, f)
+	}
+	if !found {
+		return nil
+	}
+
+	if err := c.deleteSslCertificate(mcert); err != nil {
+		return err
+	}
+
+	mcert.Finalizers = remaining
+	_, err := c.client.GkeV1alpha1().ManagedCertificates(mcert.Namespace).Update(mcert)
+	return err
 }
 
 func (c *McertController) updateStatus(mcert *api.ManagedCertificate) error {
-	sslCertificateName, exists := c.state.Get(mcert.Name)
+	sslCertificateName, exists := c.state.Get(mcert.Namespace + "/" + mcert.Name)
 	if !exists {
-		return fmt.Errorf("Failed to find in state Managed Certificate %s", mcert.Name)
+		return fmt.Errorf("Failed to find in state Managed Certificate %s/%s", mcert.Namespace, mcert.Name)
 	}
 
-	sslCert, err := c.sslClient.Get(sslCertificateName)
+	p, err := c.providerFor(mcert)
 	if err != nil {
 		return err
 	}
 
-	switch sslCert.Managed.Status {
-	case sslActive:
-		mcert.Status.CertificateStatus = "Active"
-	case sslManagedCertificateStatusUnspecified, "":
-		mcert.Status.CertificateStatus = ""
-	case sslProvisioning:
-		mcert.Status.CertificateStatus = "Provisioning"
-	case sslProvisioningFailed:
-		mcert.Status.CertificateStatus = "ProvisioningFailed"
-	case sslProvisioningFailedPermanently:
-		mcert.Status.CertificateStatus = "ProvisioningFailedPermanently"
-	case sslRenewalFailed:
-		mcert.Status.CertificateStatus = "RenewalFailed"
-	default:
-		return fmt.Errorf("Unexpected status %s of SslCertificate %v", sslCert.Managed.Status, sslCert)
+	status, err := p.Describe(sslCertificateName)
+	if err != nil {
+		return err
 	}
 
-	var domainStatus []api.DomainStatus
-	for domain, status := range sslCert.Managed.DomainStatus {
-		translatedStatus, err := translateDomainStatus(status)
-		if err != nil {
-			return err
+	previousStatus := mcert.Status.CertificateStatus
+	previousCertificateName := mcert.Status.CertificateName
+	previousDomainStatus := mcert.Status.DomainStatus
+	mcert.Status.CertificateStatus = status.CertificateStatus
+
+	if mcert.Status.CertificateStatus != previousStatus {
+		if strings.HasPrefix(mcert.Status.CertificateStatus, "Provisioning") && mcert.Status.CertificateStatus != "Provisioning" ||
+			mcert.Status.CertificateStatus == "RenewalFailed" || mcert.Status.CertificateStatus == "OrderFailed" {
+			c.recorder.Eventf(mcert, apiv1.EventTypeWarning, eventReasonStatusUpdate,
+				"Certificate status changed from %s to %s", previousStatus, mcert.Status.CertificateStatus)
+		} else {
+			c.recorder.Eventf(mcert, apiv1.EventTypeNormal, eventReasonStatusUpdate,
+				"Certificate status changed from %s to %s", previousStatus, mcert.Status.CertificateStatus)
 		}
+	}
 
-		domainStatus = append(domainStatus, api.DomainStatus{
-			Domain: domain,
-			Status: translatedStatus,
-		})
+	var domainStatus []api.DomainStatus
+	stillReported := make(map[api.DomainStatus]bool)
+	for _, ds := range status.DomainStatus {
+		entry := api.DomainStatus{Domain: ds.Domain, Status: ds.Status}
+		domainStatus = append(domainStatus, entry)
+		stillReported[entry] = true
+		metrics.DomainStatus.WithLabelValues(ds.Domain, ds.Status).Set(1)
+	}
+	// A domain that changed status (or dropped out of status.DomainStatus
+	// entirely) would otherwise leave its old gauge entry stuck at 1 forever.
+	for _, ds := range previousDomainStatus {
+		if !stillReported[ds] {
+			metrics.DomainStatus.DeleteLabelValues(ds.Domain, ds.Status)
+		}
 	}
 	mcert.Status.DomainStatus = domainStatus
-	mcert.Status.CertificateName = sslCert.Name
+	mcert.Status.CertificateName = status.CertificateName
+	if previousCertificateName != status.CertificateName || previousStatus != status.CertificateStatus {
+		metrics.SslCertificateStatus.DeleteLabelValues(previousCertificateName, previousStatus)
+	}
+	metrics.SslCertificateStatus.WithLabelValues(status.CertificateName, status.CertificateStatus).Set(1)
 
 	_, err = c.client.GkeV1alpha1().ManagedCertificates(mcert.Namespace).Update(mcert)
 	return err
 }
 
+// createSslCertificateIfNeeded is idempotent: it calls Create only if
+// sslCertificateName does not already exist with the provider.
 func (c *McertController) createSslCertificateIfNeeded(sslCertificateName string, mcert *api.ManagedCertificate) error {
-	if _, err := c.sslClient.Get(sslCertificateName); err != nil {
-		//SslCertificate does not yet exist, create it
-		glog.Infof("McertController creates a new SslCertificate %s associated with Managed Certificate %s, based on state", sslCertificateName, mcert.Name)
-		if err := c.sslClient.Create(sslCertificateName, mcert.Spec.Domains); err != nil {
+	p, err := c.providerFor(mcert)
+	if err != nil {
+		return err
+	}
+
+	exists, err := p.Exists(sslCertificateName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		glog.Infof("McertController creates a new SslCertificate %s associated with Managed Certificate %s", sslCertificateName, mcert.Name)
+		if err := p.Create(sslCertificateName, mcert.Spec.Domains); err != nil {
 			return err
 		}
+		c.recorder.Eventf(mcert, apiv1.EventTypeNormal, eventReasonCreate, "Created SslCertificate %s", sslCertificateName)
 	}
 
 	return nil
 }
 
-func (c *McertController) createSslCertificateNameIfNeeded(name string) (string, error) {
-	sslCertificateName, exists := c.state.Get(name)
+// deleteSslCertificate removes the provider-side certificate currently
+// attached to mcert and clears it from state. It has two callers: handleDeleted,
+// once the last Ingress referencing mcert goes away, and again by
+// finalizeDeletion when mcert itself is deleted - so the SslCertificate
+// (or, for the ACME provider, the kubernetes.io/tls Secret) doesn't outlive
+// every consumer that could use it, or the ManagedCertificate that owns it.
+func (c *McertController) deleteSslCertificate(mcert *api.ManagedCertificate) error {
+	stateKey := mcert.Namespace + "/" + mcert.Name
+	liveName, exists := c.state.Get(stateKey)
+	if !exists || liveName == "" {
+		return nil
+	}
 
-	if exists && sslCertificateName != "" {
-		return sslCertificateName, nil
+	p, err := c.providerFor(mcert)
+	if err != nil {
+		return err
+	}
+	if err := p.Delete(liveName); err != nil {
+		return err
 	}
 
-	//State does not have anything for this managed certificate or no SslCertificate is associated with it
-	sslCertificateName, err := c.randomName()
+	c.state.Delete(stateKey)
+	c.recorder.Eventf(mcert, apiv1.EventTypeNormal, eventReasonDelete,
+		"Deleted SslCertificate %s of Managed Certificate %s", liveName, mcert.Name)
+	return nil
+}
+
+// reconcileSslCertificateName returns the SslCertificate name that should be
+// attached to mcert right now, creating a new one and rolling it in behind
+// the scenes when mcert.Spec.Domains has changed since the last reconcile.
+//
+// The name itself is derived deterministically from the ManagedCertificate's
+// identity and sorted domain list (see package naming), so unlike the
+// random-name-plus-state scheme this replaced, losing c.state - a pod
+// restart with no PV, state corruption, a cluster migration - just means the
+// next reconcile recomputes the same name and finds the existing
+// SslCertificate already there, instead of creating and leaking a new one.
+//
+// c.state still tracks which of (possibly) two derived names is the one
+// currently attached, so that on a domain change the old SslCertificate
+// keeps serving traffic until its replacement reaches ACTIVE, at which point
+// it is promoted and the old one is deleted - the same swap-then-GC pattern
+// cert-manager uses for its CertificateRequest/Certificate split.
+//
+// Alongside the live name, c.state tracks the in-flight candidate under
+// candidateStateKeySuffix. Without it, a second Spec.Domains change arriving
+// before the first candidate reaches ACTIVE would compute a third name,
+// create yet another SslCertificate, and abandon the first candidate -
+// which is neither live nor ever reconsidered - as a permanent orphan.
+// Tracking it lets a superseded-before-promotion candidate be deleted too.
+func (c *McertController) reconcileSslCertificateName(mcert *api.ManagedCertificate) (string, error) {
+	stateKey := mcert.Namespace + "/" + mcert.Name
+	candidateKey := stateKey + candidateStateKeySuffix
+	desiredName := naming.CertificateName(mcert.Namespace, mcert.Name, mcert.Spec.Domains)
+
+	liveName, exists := c.state.Get(stateKey)
+	if !exists || liveName == "" {
+		c.state.Put(stateKey, desiredName)
+		return desiredName, nil
+	}
+	if liveName == desiredName {
+		c.state.Delete(candidateKey)
+		return liveName, nil
+	}
+
+	p, err := c.providerFor(mcert)
+	if err != nil {
+		return "", err
+	}
+
+	if candidateName, exists := c.state.Get(candidateKey); exists && candidateName != "" && candidateName != desiredName {
+		glog.Infof("McertController deletes candidate SslCertificate %s for Managed Certificate %s: superseded by %s before it was promoted", candidateName, mcert.Name, desiredName)
+		if err := p.Delete(candidateName); err != nil {
+			runtime.HandleError(fmt.Errorf("failed to delete superseded candidate SslCertificate %s: %v", candidateName, err))
+		}
+	}
+	c.state.Put(candidateKey, desiredName)
+
+	// mcert.Spec.Domains changed since liveName was derived: bring up
+	// desiredName alongside the still-attached liveName, and only switch
+	// over once it is ready.
+	if err := c.createSslCertificateIfNeeded(desiredName, mcert); err != nil {
+		return "", err
+	}
+
+	status, err := p.Describe(desiredName)
 	if err != nil {
 		return "", err
 	}
+	if status.CertificateStatus != "Active" {
+		glog.Infof("McertController keeps SslCertificate %s attached to Managed Certificate %s while %s provisions", liveName, mcert.Name, desiredName)
+		return liveName, nil
+	}
+
+	glog.Infof("McertController promotes SslCertificate %s over %s for Managed Certificate %s", desiredName, liveName, mcert.Name)
+	c.state.Put(stateKey, desiredName)
+	c.state.Delete(candidateKey)
+	if err := p.Delete(liveName); err != nil {
+		runtime.HandleError(fmt.Errorf("failed to delete superseded SslCertificate %s: %v", liveName, err))
+	}
+	c.recorder.Eventf(mcert, apiv1.EventTypeNormal, eventReasonRotate,
+		"Rotated from SslCertificate %s to %s after domain list changed", liveName, desiredName)
 
-	glog.Infof("McertController adds to state new SslCertificate name %s associated with Managed Certificate %s", sslCertificateName, name)
-	c.state.Put(name, sslCertificateName)
-	return sslCertificateName, nil
+	return desiredName, nil
 }
 
-func (c *McertController) handleMcert(key string) error {
+func (c *McertController) handleMcert(key string) (err error) {
+	defer func(start time.Time) {
+		metrics.ObserveReconcile(start, err)
+	}(time.Now())
+
 	ns, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		return err
@@ -148,7 +297,14 @@ func (c *McertController) handleMcert(key string) error {
 		return err
 	}
 
-	sslCertificateName, err := c.createSslCertificateNameIfNeeded(name)
+	if mcert.DeletionTimestamp != nil {
+		return c.finalizeDeletion(mcert)
+	}
+	if err := c.ensureFinalizer(mcert); err != nil {
+		return err
+	}
+
+	sslCertificateName, err := c.reconcileSslCertificateName(mcert)
 	if err != nil {
 		return err
 	}
@@ -161,6 +317,8 @@ func (c *McertController) handleMcert(key string) error {
 }
 
 func (c *McertController) processNext() bool {
+	metrics.QueueDepth.WithLabelValues("mcert").Set(float64(c.queue.Len()))
+
 	obj, shutdown := c.queue.Get()
 
 	if shutdown {
@@ -178,6 +336,10 @@ func (c *McertController) processNext() bool {
 
 	if err := c.handleMcert(key); err != nil {
 		c.queue.AddRateLimited(obj)
+		if mcert := c.mcertForKey(key); mcert != nil {
+			c.recorder.Eventf(mcert, apiv1.EventTypeWarning, eventReasonRateLimited,
+				"Requeued Managed Certificate %s after error: %v", key, err)
+		}
 		runtime.HandleError(err)
 	}
 
@@ -186,25 +348,22 @@ func (c *McertController) processNext() bool {
 	return true
 }
 
-func (c *McertController) runWorker() {
-	for c.processNext() {
+// mcertForKey is a best-effort lookup used only for attaching events to the
+// right object; a miss (e.g. the object was deleted) is not itself an error.
+func (c *McertController) mcertForKey(key string) *api.ManagedCertificate {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
 	}
-}
 
-func (c *McertController) randomName() (string, error) {
-	name, err := utils.RandomName()
+	mcert, err := c.lister.ManagedCertificates(ns).Get(name)
 	if err != nil {
-		return "", err
+		return nil
 	}
+	return mcert
+}
 
-	_, err = c.sslClient.Get(name)
-	if err == nil {
-		//Name taken, choose a new one
-		name, err = utils.RandomName()
-		if err != nil {
-			return "", err
-		}
+func (c *McertController) runWorker() {
+	for c.processNext() {
 	}
-
-	return name, nil
 }
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package healthz serves the /healthz and /readyz endpoints this controller
+// exposes alongside /metrics.
+package healthz
+
+import (
+	"net/http"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Checker reports the controller's readiness for /readyz: whether both
+// informers have completed their initial sync and the most recent list
+// against the provider's backing API succeeded at least once.
+type Checker struct {
+	IngressSynced func() bool
+	McertSynced   func() bool
+	ProviderReady func() bool
+
+	IngressQueue workqueue.RateLimitingInterface
+	McertQueue   workqueue.RateLimitingInterface
+}
+
+// Healthz reports live as long as neither workqueue has been shut down;
+// that's the only failure mode that means this process can no longer make
+// progress and should be restarted.
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	if c.IngressQueue.ShuttingDown() || c.McertQueue.ShuttingDown() {
+		http.Error(w, "a controller workqueue is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Readyz reports ready once both informers have synced and the provider has
+// listed successfully at least once, so a Pod isn't sent traffic before it
+// has a view of cluster and provider state to reconcile against.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !c.IngressSynced() {
+		http.Error(w, "ingress informer has not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	if !c.McertSynced() {
+		http.Error(w, "managed certificate informer has not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	if !c.ProviderReady() {
+		http.Error(w, "no successful provider list yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics registers the Prometheus metrics this controller exposes
+// on /metrics, so stuck reconciliation of the mcert queue or a GCE API that
+// has started erroring can be alerted on instead of discovered by tailing
+// logs.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "mcert"
+
+var (
+	// ReconcileTotal counts McertController.handleMcert invocations by
+	// outcome, result being either "success" or "error".
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Number of ManagedCertificate reconciliations, by result.",
+	}, []string{"result"})
+
+	// ReconcileDuration observes how long a single handleMcert call took.
+	ReconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time spent in a single ManagedCertificate reconciliation.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// SslCertificateStatus is 1 for the (name, status) pair currently
+	// reported by the provider for a certificate. Once the name or status
+	// changes, the previous pair is removed rather than set to 0.
+	SslCertificateStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ssl_certificate_status",
+		Help:      "Whether a provider certificate currently reports the given status.",
+	}, []string{"name", "status"})
+
+	// DomainStatus is the same shape as SslCertificateStatus, but per domain.
+	DomainStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "domain_status",
+		Help:      "Whether a domain currently reports the given status.",
+	}, []string{"domain", "status"})
+
+	// QueueDepth tracks the number of items waiting in a controller's
+	// workqueue, by queue name ("ingress" or "mcert").
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of items currently in a controller workqueue.",
+	}, []string{"queue"})
+
+	// ProviderCallDuration observes GCE API call latency, by provider method.
+	ProviderCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_call_duration_seconds",
+		Help:      "Latency of calls to the certificate provider's backing API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "method"})
+
+	// ProviderCallErrors counts failed calls to the certificate provider's
+	// backing API, by provider method.
+	ProviderCallErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_call_errors_total",
+		Help:      "Number of failed calls to the certificate provider's backing API.",
+	}, []string{"provider", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(ReconcileTotal, ReconcileDuration, SslCertificateStatus,
+		DomainStatus, QueueDepth, ProviderCallDuration, ProviderCallErrors)
+}
+
+// ObserveReconcile records the outcome and duration of a single handleMcert
+// call; callers defer it with the start time captured on entry.
+func ObserveReconcile(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	ReconcileTotal.WithLabelValues(result).Inc()
+	ReconcileDuration.Observe(time.Since(start).Seconds())
+}
+
+// ObserveProviderCall records the latency and, on failure, an error count
+// for a single call a CertificateProvider makes to its backing API.
+func ObserveProviderCall(provider, method string, start time.Time, err error) {
+	ProviderCallDuration.WithLabelValues(provider, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ProviderCallErrors.WithLabelValues(provider, method).Inc()
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package naming derives deterministic, idempotent SslCertificate names so
+// McertController can look up a ManagedCertificate's provider-side
+// certificate by name alone. Previously names were random and persisted in
+// c.state; if that state was ever lost - a pod restart with no PV, state
+// corruption, a cluster migration - the controller would re-create
+// SslCertificates and leak the old ones under their now-forgotten names.
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+const (
+	prefix = "mcrt"
+
+	// identityHashLen and domainsHashLen are kept short because the result
+	// feeds into GCE resource names, which cap out at 63 characters.
+	identityHashLen = 8
+	domainsHashLen  = 8
+)
+
+// CertificateName returns the deterministic SslCertificate name for the
+// ManagedCertificate namespace/name covering domains. The name is the
+// concatenation of two hashes: one over the ManagedCertificate's identity,
+// so two ManagedCertificates never collide, and one over its sorted domain
+// list, so the name changes - and the controller provisions a new
+// SslCertificate instead of mutating the old one in place - whenever the
+// domain set changes, and only then.
+func CertificateName(namespace, name string, domains []string) string {
+	return fmt.Sprintf("%s-%s-%s", prefix, identityHash(namespace, name), domainsHash(domains))
+}
+
+func identityHash(namespace, name string) string {
+	sum := sha256.Sum256([]byte(namespace + "/" + name))
+	return hex.EncodeToString(sum[:])[:identityHashLen]
+}
+
+func domainsHash(domains []string) string {
+	sorted := append([]string(nil), domains...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, domain := range sorted {
+		h.Write([]byte(domain))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:domainsHashLen]
+}
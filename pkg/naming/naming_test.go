@@ -0,0 +1,43 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package naming
+
+import "testing"
+
+func TestCertificateName(t *testing.T) {
+	a := CertificateName("default", "foo", []string{"a.com", "b.com"})
+	b := CertificateName("default", "foo", []string{"a.com", "b.com"})
+	if a != b {
+		t.Errorf("CertificateName is not deterministic: %s != %s", a, b)
+	}
+
+	if got := CertificateName("default", "foo", []string{"b.com", "a.com"}); got != a {
+		t.Errorf("CertificateName(%v) = %s, want %s (order of domains should not matter)", []string{"b.com", "a.com"}, got, a)
+	}
+
+	if got := CertificateName("other", "foo", []string{"a.com", "b.com"}); got == a {
+		t.Errorf("CertificateName for a different namespace collided with %s", a)
+	}
+
+	if got := CertificateName("default", "bar", []string{"a.com", "b.com"}); got == a {
+		t.Errorf("CertificateName for a different name collided with %s", a)
+	}
+
+	if got := CertificateName("default", "foo", []string{"a.com", "c.com"}); got == a {
+		t.Errorf("CertificateName for a different domain set collided with %s", a)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package acme implements provider.CertificateProvider against Let's
+// Encrypt (or any ACME-compatible CA), so ManagedCertificates work on
+// non-GKE clusters that have no GCE managed SslCertificate API available.
+// Issued certificate material is stored in a kubernetes.io/tls Secret that
+// an Ingress can reference directly via spec.tls.secretName.
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+
+	"golang.org/x/crypto/acme"
+	apiv1 "k8s.io/api/core/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"managed-certs-gke/pkg/provider"
+)
+
+// Solver completes an ACME challenge for domain and reports when it is no
+// longer needed.
+type Solver interface {
+	Present(ctx context.Context, domain, token, keyAuth string) error
+	CleanUp(ctx context.Context, domain, token string) error
+}
+
+// Provider is the ACME-backed provider.CertificateProvider. HTTP-01
+// challenges are served by httpSolver from behind the Ingress the
+// ManagedCertificate is attached to; DNS-01 challenges are completed by
+// dnsSolver against Cloud DNS. Either may be nil if that challenge type is
+// not configured.
+type Provider struct {
+	client     *acme.Client
+	secrets    corev1.SecretsGetter
+	namespace  string
+	httpSolver Solver
+	dnsSolver  Solver
+	orders     map[string]*orderState
+}
+
+// orderState tracks the in-flight ACME order for a certificate name. It is
+// intentionally in-memory: a restart simply re-orders, which is safe
+// because ACME issuance is idempotent per account+domain-set.
+type orderState struct {
+	orderURL string
+	status   string
+	problem  string
+}
+
+// New returns a Provider that stores issued certificates as
+// kubernetes.io/tls Secrets in namespace.
+func New(client *acme.Client, secrets corev1.SecretsGetter, namespace string, httpSolver, dnsSolver Solver) *Provider {
+	return &Provider{
+		client:     client,
+		secrets:    secrets,
+		namespace:  namespace,
+		httpSolver: httpSolver,
+		dnsSolver:  dnsSolver,
+		orders:     make(map[string]*orderState),
+	}
+}
+
+func (p *Provider) Exists(name string) (bool, error) {
+	if _, err := p.secrets.Secrets(p.namespace).Get(name, metaGetOptions()); err == nil {
+		return true, nil
+	}
+	if _, tracked := p.orders[name]; tracked {
+		return true, nil
+	}
+	return false, nil
+}
+
+// Create starts ACME issuance for domains under name, placing a new order
+// on every call. It
+// authorizes each domain with whichever solver is configured, finalizes the
+// order once every challenge is validated, and stores the resulting
+// certificate and key in a kubernetes.io/tls Secret named name.
+func (p *Provider) Create(name string, domains []string) error {
+	ctx := context.Background()
+
+	authzURLs, orderURL, err := p.client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		p.orders[name] = &orderState{status: "OrderFailed", problem: err.Error()}
+		return fmt.Errorf("failed to create ACME order for %v: %v", domains, err)
+	}
+	p.orders[name] = &orderState{orderURL: orderURL, status: "Pending"}
+
+	for i, authzURL := range authzURLs {
+		if err := p.authorize(ctx, domains[i], authzURL); err != nil {
+			p.orders[name] = &orderState{orderURL: orderURL, status: "ChallengeFailed", problem: err.Error()}
+			return err
+		}
+	}
+
+	key, csrDER, err := newCertificateRequest(domains)
+	if err != nil {
+		return fmt.Errorf("failed to generate CSR for %v: %v", domains, err)
+	}
+
+	der, _, err := p.client.CreateOrderCert(ctx, orderURL, csrDER, true)
+	if err != nil {
+		p.orders[name] = &orderState{orderURL: orderURL, status: "OrderFailed", problem: err.Error()}
+		return fmt.Errorf("failed to finalize ACME order for %v: %v", domains, err)
+	}
+
+	secret, err := tlsSecret(name, key, der)
+	if err != nil {
+		return err
+	}
+	if _, err := p.secrets.Secrets(p.namespace).Create(secret); err != nil {
+		return fmt.Errorf("failed to store certificate Secret %s: %v", name, err)
+	}
+
+	delete(p.orders, name)
+	return nil
+}
+
+// newCertificateRequest generates a fresh private key for the certificate
+// and a CSR requesting domains, the DER encoding of which is handed to
+// acme.Client.CreateOrderCert to finalize the order. The key is returned
+// alongside so it can be stored with the issued certificate; it is never
+// shared with the ACME account key, which only signs requests to the CA.
+func newCertificateRequest(domains []string) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		DNSNames: domains,
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, csrDER, nil
+}
+
+func (p *Provider) authorize(ctx context.Context, domain, authzURL string) error {
+	authz, err := p.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+
+	solver := p.httpSolver
+	challengeType := "http-01"
+	if solver == nil {
+		solver = p.dnsSolver
+		challengeType = "dns-01"
+	}
+	if solver == nil {
+		return fmt.Errorf("no ACME challenge solver configured for domain %s", domain)
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == challengeType {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no %s challenge offered for domain %s", challengeType, domain)
+	}
+
+	var response string
+	switch challengeType {
+	case "http-01":
+		response, err = p.client.HTTP01ChallengeResponse(challenge.Token)
+	case "dns-01":
+		response, err = p.client.DNS01ChallengeRecord(challenge.Token)
+	}
+	if err != nil {
+		return err
+	}
+	if err := solver.Present(ctx, domain, challenge.Token, response); err != nil {
+		return err
+	}
+	defer solver.CleanUp(ctx, domain, challenge.Token)
+
+	if _, err := p.client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+	_, err = p.client.WaitAuthorization(ctx, authzURL)
+	return err
+}
+
+func (p *Provider) Delete(name string) error {
+	delete(p.orders, name)
+	err := p.secrets.Secrets(p.namespace).Delete(name, nil)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (p *Provider) Describe(name string) (provider.Status, error) {
+	if order, pending := p.orders[name]; pending {
+		status := order.status
+		domainStatus := provider.DomainStatus{Domain: name, Status: status}
+		if order.problem != "" {
+			domainStatus.Status = "Failed"
+		}
+		return provider.Status{
+			CertificateName:   name,
+			CertificateStatus: status,
+			DomainStatus:      []provider.DomainStatus{domainStatus},
+		}, nil
+	}
+
+	secret, err := p.secrets.Secrets(p.namespace).Get(name, metaGetOptions())
+	if err != nil {
+		return provider.Status{}, err
+	}
+	if _, ok := secret.Data[apiv1.TLSCertKey]; !ok {
+		return provider.Status{}, fmt.Errorf("Secret %s has no %s entry", name, apiv1.TLSCertKey)
+	}
+
+	return provider.Status{
+		CertificateName:   name,
+		CertificateStatus: "Active",
+	}, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// tlsSecret packages der, a chain of DER-encoded certificates as returned
+// by acme.Client.CreateOrderCert, together with the private key generated
+// for the CSR that was finalized into that chain, into a kubernetes.io/tls
+// Secret named name. Both TLSCertKey and TLSPrivateKeyKey must be set for
+// an Ingress referencing this Secret to be able to terminate TLS with it;
+// the ACME account key is never involved here, since it only signs
+// requests to the CA and is not the certificate's own serving key.
+func tlsSecret(name string, key *ecdsa.PrivateKey, der [][]byte) (*apiv1.Secret, error) {
+	var chain bytes.Buffer
+	for _, cert := range der {
+		if _, err := x509.ParseCertificate(cert); err != nil {
+			return nil, err
+		}
+		if err := pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert}); err != nil {
+			return nil, err
+		}
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var keyPEM bytes.Buffer
+	if err := pem.Encode(&keyPEM, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}); err != nil {
+		return nil, err
+	}
+
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Type: apiv1.SecretTypeTLS,
+		Data: map[string][]byte{
+			apiv1.TLSCertKey:       chain.Bytes(),
+			apiv1.TLSPrivateKeyKey: keyPEM.Bytes(),
+		},
+	}, nil
+}
+
+func metaGetOptions() metav1.GetOptions {
+	return metav1.GetOptions{}
+}
+
+func isNotFound(err error) bool {
+	return apierrors.IsNotFound(err)
+}
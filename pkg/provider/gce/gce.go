@@ -0,0 +1,156 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gce implements provider.CertificateProvider on top of GCE managed
+// SslCertificates - the only backend this controller supported before ACME
+// support was added, and still the default for clusters running on GKE.
+package gce
+
+import (
+	"fmt"
+	"time"
+
+	compute "google.golang.org/api/compute/v0.alpha"
+
+	"managed-certs-gke/pkg/metrics"
+	"managed-certs-gke/pkg/provider"
+	"managed-certs-gke/pkg/sslcertificatemanager"
+)
+
+// providerName is the label value this provider reports itself as on the
+// metrics.ProviderCallDuration/ProviderCallErrors metrics.
+const providerName = "gce"
+
+const (
+	sslActive                              = "ACTIVE"
+	sslFailedNotVisible                    = "FAILED_NOT_VISIBLE"
+	sslFailedCaaChecking                   = "FAILED_CAA_CHECKING"
+	sslFailedCaaForbidden                  = "FAILED_CAA_FORBIDDEN"
+	sslFailedRateLimited                   = "FAILED_RATE_LIMITED"
+	sslManagedCertificateStatusUnspecified = "MANAGED_CERTIFICATE_STATUS_UNSPECIFIED"
+	sslProvisioning                        = "PROVISIONING"
+	sslProvisioningFailed                  = "PROVISIONING_FAILED"
+	sslProvisioningFailedPermanently       = "PROVISIONING_FAILED_PERMANENTLY"
+	sslRenewalFailed                       = "RENEWAL_FAILED"
+)
+
+// Provider is the GCE-backed provider.CertificateProvider.
+type Provider struct {
+	client sslcertificatemanager.SslCertificateManager
+}
+
+// New returns a Provider that issues certificates through client.
+func New(client sslcertificatemanager.SslCertificateManager) *Provider {
+	return &Provider{client: client}
+}
+
+func (p *Provider) get(name string) (*compute.SslCertificate, error) {
+	start := time.Now()
+	sslCert, err := p.client.Get(name)
+	metrics.ObserveProviderCall(providerName, "Get", start, err)
+	return sslCert, err
+}
+
+func (p *Provider) Exists(name string) (bool, error) {
+	if _, err := p.get(name); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (p *Provider) Create(name string, domains []string) error {
+	start := time.Now()
+	err := p.client.Create(name, domains)
+	metrics.ObserveProviderCall(providerName, "Create", start, err)
+	return err
+}
+
+func (p *Provider) Delete(name string) error {
+	start := time.Now()
+	err := p.client.Delete(name)
+	metrics.ObserveProviderCall(providerName, "Delete", start, err)
+	return err
+}
+
+func (p *Provider) Describe(name string) (provider.Status, error) {
+	sslCert, err := p.get(name)
+	if err != nil {
+		return provider.Status{}, err
+	}
+
+	certificateStatus, err := translateCertificateStatus(sslCert.Managed.Status)
+	if err != nil {
+		return provider.Status{}, err
+	}
+
+	var domainStatus []provider.DomainStatus
+	for domain, status := range sslCert.Managed.DomainStatus {
+		translated, err := translateDomainStatus(status)
+		if err != nil {
+			return provider.Status{}, err
+		}
+
+		domainStatus = append(domainStatus, provider.DomainStatus{
+			Domain: domain,
+			Status: translated,
+		})
+	}
+
+	return provider.Status{
+		CertificateName:   sslCert.Name,
+		CertificateStatus: certificateStatus,
+		ExpireTime:        sslCert.ExpireTime,
+		DomainStatus:      domainStatus,
+	}, nil
+}
+
+func translateCertificateStatus(status string) (string, error) {
+	switch status {
+	case sslActive:
+		return "Active", nil
+	case sslManagedCertificateStatusUnspecified, "":
+		return "", nil
+	case sslProvisioning:
+		return "Provisioning", nil
+	case sslProvisioningFailed:
+		return "ProvisioningFailed", nil
+	case sslProvisioningFailedPermanently:
+		return "ProvisioningFailedPermanently", nil
+	case sslRenewalFailed:
+		return "RenewalFailed", nil
+	default:
+		return "", fmt.Errorf("Unexpected status %s of SslCertificate", status)
+	}
+}
+
+func translateDomainStatus(status string) (string, error) {
+	switch status {
+	case sslProvisioning:
+		return "Provisioning", nil
+	case sslFailedNotVisible:
+		return "FailedNotVisible", nil
+	case sslFailedCaaChecking:
+		return "FailedCaaChecking", nil
+	case sslFailedCaaForbidden:
+		return "FailedCaaForbidden", nil
+	case sslFailedRateLimited:
+		return "FailedRateLimited", nil
+	case sslActive:
+		return "Active", nil
+	default:
+		return "", fmt.Errorf("Unexpected status %s", status)
+	}
+}
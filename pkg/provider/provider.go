@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package provider defines the CertificateProvider abstraction that lets
+// McertController issue certificates against more than one backend - the
+// GCE managed SslCertificate API (package gce) or ACME/Let's Encrypt
+// (package acme) - while exposing a single, provider-agnostic status shape
+// to the rest of the controller.
+package provider
+
+// Name identifies which CertificateProvider a ManagedCertificate is
+// provisioned through. It is read from spec.Provider, defaulting to GCE so
+// existing ManagedCertificates keep behaving exactly as before.
+type Name string
+
+const (
+	GCE  Name = "gce"
+	ACME Name = "acme"
+)
+
+// DomainStatus is the provider-agnostic state of a single domain within a
+// certificate, already translated from whatever vocabulary the backing
+// provider uses into the vocabulary the ManagedCertificate status API
+// exposes.
+type DomainStatus struct {
+	Domain string
+	Status string
+}
+
+// Status is the provider-agnostic state of a certificate as a whole,
+// returned by Describe.
+type Status struct {
+	CertificateName string
+	CertificateStatus string
+	ExpireTime string
+	DomainStatus []DomainStatus
+}
+
+// CertificateProvider is implemented once per certificate backend. Create
+// is not idempotent: callers check Exists first and call Create only for a
+// name that does not exist yet.
+type CertificateProvider interface {
+	// Exists reports whether a certificate resource for name has already
+	// been provisioned with this provider.
+	Exists(name string) (bool, error)
+
+	// Create starts issuance of a certificate covering domains.
+	Create(name string, domains []string) error
+
+	// Delete removes the provider-side certificate resource for name.
+	Delete(name string) error
+
+	// Describe returns the provider-agnostic status of the certificate
+	// resource for name.
+	Describe(name string) (Status, error)
+}
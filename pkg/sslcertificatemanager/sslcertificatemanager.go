@@ -0,0 +1,29 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sslcertificatemanager wraps the GCE SslCertificates API surface
+// used by the gce certificate provider.
+package sslcertificatemanager
+
+import compute "google.golang.org/api/compute/v0.alpha"
+
+// SslCertificateManager is the narrow client surface the gce provider
+// needs against GCE managed SslCertificates.
+type SslCertificateManager interface {
+	Get(name string) (*compute.SslCertificate, error)
+	Create(name string, domains []string) error
+	Delete(name string) error
+}